@@ -14,15 +14,21 @@
 package log
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
 	"flag"
 	"fmt"
+	"io"
+	"io/fs"
 	"math"
 	"os"
 	"os/user"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -55,6 +61,245 @@ func TemporarilyDisableFileGCForMainLogger() (cleanup func()) {
 	}
 }
 
+// LogsDroppedForMainLogger returns the number of log entries the main
+// logger's file sink has dropped because its async queue (see
+// startAsyncWriter) was full at below-ERROR severity. It returns 0 if
+// the main logger has no file sink, or the file sink isn't in async
+// mode.
+func LogsDroppedForMainLogger() uint64 {
+	fileSink := debugLog.getFileSink()
+	if fileSink == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&fileSink.logsDropped)
+}
+
+// logFS abstracts the filesystem operations fileSink needs, so a sink
+// can be pointed at something other than the local disk (e.g. an
+// object-storage or tmpfs-backed virtual filesystem) without an
+// external sidecar, and so test Scopes can run without touching a real
+// filesystem at all.
+type logFS interface {
+	// OpenAppend opens (creating if necessary) name for appending log
+	// entries, returning a writer compatible with fileSink.mu.file.
+	OpenAppend(name string) (flushSyncWriter, error)
+	// Create creates name, truncating it first if it already exists,
+	// e.g. for a compressed file that must not inherit the contents of
+	// a leftover partial file from a previous, interrupted compression.
+	Create(name string) (flushSyncWriter, error)
+	// Open opens name for reading, e.g. to compress a rotated-out file.
+	Open(name string) (io.ReadCloser, error)
+	// Remove deletes name.
+	Remove(name string) error
+	// Symlink creates newname as a symbolic link to oldname.
+	Symlink(oldname, newname string) error
+	// ReadDir lists the entries of dir, for the GC daemon.
+	ReadDir(dir string) ([]fs.FileInfo, error)
+	// Stat returns file info for name.
+	Stat(name string) (fs.FileInfo, error)
+}
+
+// osLogFS is the default logFS, backed directly by the local
+// filesystem via the os package.
+type osLogFS struct{}
+
+var _ logFS = osLogFS{}
+
+// osFile adapts *os.File to flushSyncWriter. os.File writes go straight
+// to the OS via unbuffered syscalls, so Flush is a no-op.
+type osFile struct{ *os.File }
+
+func (osFile) Flush() error { return nil }
+
+// OpenAppend implements logFS.
+func (osLogFS) OpenAppend(name string) (flushSyncWriter, error) {
+	f, err := os.OpenFile(name, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return osFile{f}, nil
+}
+
+// Create implements logFS.
+func (osLogFS) Create(name string) (flushSyncWriter, error) {
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return osFile{f}, nil
+}
+
+// Open implements logFS.
+func (osLogFS) Open(name string) (io.ReadCloser, error) { return os.Open(name) }
+
+// Remove implements logFS.
+func (osLogFS) Remove(name string) error { return os.Remove(name) }
+
+// Symlink implements logFS.
+func (osLogFS) Symlink(oldname, newname string) error { return os.Symlink(oldname, newname) }
+
+// ReadDir implements logFS.
+func (osLogFS) ReadDir(dir string) ([]fs.FileInfo, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]fs.FileInfo, 0, len(entries))
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// Stat implements logFS.
+func (osLogFS) Stat(name string) (fs.FileInfo, error) { return os.Stat(name) }
+
+// memLogFS is an in-memory logFS, for test Scopes that want to exercise
+// fileSink's rotation, compression and GC logic without touching the
+// real filesystem.
+type memLogFS struct {
+	mu struct {
+		syncutil.Mutex
+		files map[string]*memFile
+	}
+}
+
+var _ logFS = (*memLogFS)(nil)
+
+// newMemLogFS constructs an empty in-memory logFS.
+func newMemLogFS() *memLogFS {
+	fsys := &memLogFS{}
+	fsys.mu.files = make(map[string]*memFile)
+	return fsys
+}
+
+// memFile is the in-memory backing store for a single file opened
+// through memLogFS.
+type memFile struct {
+	mu struct {
+		syncutil.Mutex
+		data    []byte
+		modTime time.Time
+	}
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.mu.data = append(f.mu.data, p...)
+	f.mu.modTime = timeutil.Now()
+	return len(p), nil
+}
+
+func (f *memFile) Flush() error { return nil }
+func (f *memFile) Sync() error  { return nil }
+func (f *memFile) Close() error { return nil }
+
+func (f *memFile) snapshot() ([]byte, time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	data := make([]byte, len(f.mu.data))
+	copy(data, f.mu.data)
+	return data, f.mu.modTime
+}
+
+// OpenAppend implements logFS.
+func (fsys *memLogFS) OpenAppend(name string) (flushSyncWriter, error) {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+	f, ok := fsys.mu.files[name]
+	if !ok {
+		f = &memFile{}
+		f.mu.modTime = timeutil.Now()
+		fsys.mu.files[name] = f
+	}
+	return f, nil
+}
+
+// Create implements logFS.
+func (fsys *memLogFS) Create(name string) (flushSyncWriter, error) {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+	f := &memFile{}
+	f.mu.modTime = timeutil.Now()
+	fsys.mu.files[name] = f
+	return f, nil
+}
+
+// Open implements logFS.
+func (fsys *memLogFS) Open(name string) (io.ReadCloser, error) {
+	fsys.mu.Lock()
+	f, ok := fsys.mu.files[name]
+	fsys.mu.Unlock()
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	data, _ := f.snapshot()
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+// Remove implements logFS.
+func (fsys *memLogFS) Remove(name string) error {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+	if _, ok := fsys.mu.files[name]; !ok {
+		return &os.PathError{Op: "remove", Path: name, Err: os.ErrNotExist}
+	}
+	delete(fsys.mu.files, name)
+	return nil
+}
+
+// Symlink implements logFS. It is a no-op: in-memory test Scopes don't
+// need a readable symlink target, only a name that GC won't trip over.
+func (fsys *memLogFS) Symlink(oldname, newname string) error { return nil }
+
+// ReadDir implements logFS. It returns the files directly under dir,
+// matching the shallow, non-recursive semantics of os.ReadDir.
+func (fsys *memLogFS) ReadDir(dir string) ([]fs.FileInfo, error) {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+	prefix := filepath.Clean(dir) + string(filepath.Separator)
+	var infos []fs.FileInfo
+	for name, f := range fsys.mu.files {
+		if !strings.HasPrefix(name, prefix) || strings.Contains(name[len(prefix):], string(filepath.Separator)) {
+			continue
+		}
+		data, modTime := f.snapshot()
+		infos = append(infos, &memFileInfo{name: filepath.Base(name), size: int64(len(data)), modTime: modTime})
+	}
+	return infos, nil
+}
+
+// Stat implements logFS.
+func (fsys *memLogFS) Stat(name string) (fs.FileInfo, error) {
+	fsys.mu.Lock()
+	f, ok := fsys.mu.files[name]
+	fsys.mu.Unlock()
+	if !ok {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+	}
+	data, modTime := f.snapshot()
+	return &memFileInfo{name: filepath.Base(name), size: int64(len(data)), modTime: modTime}, nil
+}
+
+// memFileInfo is a minimal fs.FileInfo for files tracked by memLogFS.
+type memFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+func (fi *memFileInfo) Name() string       { return fi.name }
+func (fi *memFileInfo) Size() int64        { return fi.size }
+func (fi *memFileInfo) Mode() fs.FileMode  { return 0644 }
+func (fi *memFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *memFileInfo) IsDir() bool        { return false }
+func (fi *memFileInfo) Sys() interface{}   { return nil }
+
 // fileSink represents a file sink.
 type fileSink struct {
 	// whether the sink is enabled.
@@ -66,6 +311,12 @@ type fileSink struct {
 	// name prefix for log files.
 	prefix string
 
+	// fs is the filesystem this sink reads and writes through. It
+	// defaults to osLogFS{} and can be overridden (e.g. by test Scopes
+	// using memLogFS, or a sink that ships logs to object storage) via
+	// newFileSink's fsOverride parameter.
+	fs logFS
+
 	// syncWrites if true calls file.Flush and file.Sync on every log
 	// write. This can be set per-logger e.g. for audit logging.
 	//
@@ -82,6 +333,14 @@ type fileSink struct {
 	// temporarily be up to logFileMaxSize larger.
 	logFilesCombinedMaxSize int64
 
+	// maxBackups, if non-zero, caps the number of rotated-out log files
+	// retained for this logger, independent of logFilesCombinedMaxSize.
+	// When both are set, the GC daemon removes a file if it violates
+	// either constraint; when only maxBackups is set, total size is
+	// ignored. Zero means the file count is unbounded (subject still to
+	// logFilesCombinedMaxSize).
+	maxBackups int
+
 	// Level beyond which entries submitted to this sink are written
 	// to the output file. This acts as a filter between the log entry
 	// producers and the file sink.
@@ -93,10 +352,73 @@ type fileSink struct {
 	// notify GC daemon that a new log file was created.
 	gcNotify chan struct{}
 
+	// gcDoneC, when non-nil, is closed to stop the GC daemon goroutine
+	// started in newFileSink.
+	gcDoneC chan struct{}
+
 	// getStartLines retrieves a list of log entries to
 	// include at the start of a log file.
 	getStartLines func(time.Time) []logpb.Entry
 
+	// rotateInterval, if non-zero, causes this sink to rotate onto a new
+	// file at every wall-clock boundary of this duration (e.g. hourly or
+	// daily), independently of logFileMaxSize. Zero disables time-based
+	// rotation.
+	rotateInterval time.Duration
+
+	// filenamePattern, if non-empty, overrides the default timestamp
+	// formatting of rotated file names with a strftime-style template
+	// (e.g. "%Y-%m-%dT%H"). This is intended to let operators align log
+	// file names with external log-shipping pipelines. See
+	// formatFilename.
+	filenamePattern string
+
+	// rotateDoneC, when non-nil, is closed to stop the time-based
+	// rotation goroutine started in newFileSink.
+	rotateDoneC chan struct{}
+
+	// compress, if true, causes rotated-out log files to be gzip
+	// compressed in the background (see startCompressWorkers). Enabling
+	// this does not affect the file currently being written to.
+	compress bool
+
+	// compressLevel is the compress/gzip level used when compress is
+	// set. Callers should use a valid gzip level (gzip.DefaultCompression
+	// if unsure).
+	compressLevel int
+
+	// compressC queues filenames of just rotated-out log files for the
+	// background compression workers started by startCompressWorkers.
+	compressC chan string
+
+	// compressWG is used by Shutdown to wait for in-flight compressions
+	// to finish, so tests and graceful shutdowns don't race with the
+	// compressor creating/removing files out from under them. Flush does
+	// not wait on it.
+	compressWG sync.WaitGroup
+
+	// asyncC, when non-nil, is the queue fed by output() in async mode
+	// and drained by runAsyncWriter on a dedicated goroutine, so that a
+	// slow disk stalls only that goroutine rather than every logging
+	// goroutine that calls into this sink. Its capacity is derived from
+	// asyncBufferBytes in newFileSink. Async mode is refused for a sink
+	// with syncWrites set, see startAsyncWriter.
+	asyncC chan *asyncLogEntry
+
+	// asyncFlushInterval is how often the async writer goroutine calls
+	// flushAndSyncLocked on its own, so that low-volume logs still land
+	// on disk promptly even without the channel filling up.
+	asyncFlushInterval time.Duration
+
+	// asyncWG lets Shutdown wait for the async writer goroutine to drain
+	// asyncC and exit.
+	asyncWG sync.WaitGroup
+
+	// logsDropped counts log entries dropped because asyncC was full and
+	// the entry was below ERROR severity. Read via
+	// LogsDroppedForMainLogger.
+	logsDropped uint64
+
 	// mu protects the remaining elements of this structure and is
 	// used to synchronize output to this file sink..
 	mu struct {
@@ -110,6 +432,24 @@ type fileSink struct {
 		// file holds the log file writer.
 		file flushSyncWriter
 
+		// filename is the path of the file currently held open in file.
+		// It is used to enqueue the file for background compression once
+		// it is rotated out.
+		filename string
+
+		// lastRotation is the unix timestamp (seconds) used to generate
+		// the name of the most recently created log file. It is kept
+		// strictly increasing across calls to create(), see the
+		// updatedRotation return value there.
+		lastRotation int64
+
+		// filesInInterval counts how many files have been created since
+		// the last time-based rotation boundary was crossed. It is used
+		// to disambiguate a size-triggered rotation that happens within
+		// the same interval as the interval's primary file, so that file
+		// does not get clobbered (see logName's seq argument).
+		filesInInterval int
+
 		// redirectInternalStderrWrites, when set, causes this file sink to
 		// capture writes to system-wide file descriptor 2 (the standard
 		// error stream) and os.Stderr and redirect them to this sink's
@@ -141,27 +481,311 @@ func newFileSink(
 	forceSyncWrites bool,
 	fileThreshold Severity,
 	fileMaxSize, combinedMaxSize int64,
+	maxBackups int,
 	getStartLines func(time.Time) []logpb.Entry,
+	rotateInterval time.Duration,
+	filenamePattern string,
+	compress bool,
+	compressLevel int,
+	asyncBufferBytes int64,
+	fsOverride logFS,
 ) *fileSink {
 	prefix := program
 	if fileNamePrefix != "" {
 		prefix = program + "-" + fileNamePrefix
 	}
+	fsys := logFS(osLogFS{})
+	if fsOverride != nil {
+		fsys = fsOverride
+	}
 	f := &fileSink{
 		prefix:                  prefix,
+		fs:                      fsys,
 		threshold:               fileThreshold,
 		formatter:               formatCrdbV1WithCounter{},
 		syncWrites:              forceSyncWrites,
 		logFileMaxSize:          fileMaxSize,
 		logFilesCombinedMaxSize: combinedMaxSize,
+		maxBackups:              maxBackups,
 		gcNotify:                make(chan struct{}, 1),
 		getStartLines:           getStartLines,
+		rotateInterval:          rotateInterval,
+		filenamePattern:         filenamePattern,
+		compress:                compress,
+		compressLevel:           compressLevel,
+		asyncFlushInterval:      defaultAsyncFlushInterval,
 	}
 	f.mu.logDir = dir
 	f.enabled.Set(dir != "")
+	if rotateInterval > 0 {
+		f.rotateDoneC = make(chan struct{})
+		go f.runRotateTicker()
+	}
+	if compress {
+		f.startCompressWorkers()
+	}
+	if asyncBufferBytes > 0 {
+		f.startAsyncWriter(asyncBufferBytes)
+	}
+	f.gcDoneC = make(chan struct{})
+	go f.runGCDaemon()
 	return f
 }
 
+// runGCDaemon enforces logFilesCombinedMaxSize and maxBackups for this
+// sink. It wakes up whenever gcNotify fires (i.e. whenever createLocked
+// installs a new log file, or a rotated-out file has been through a
+// compression attempt) and removes whichever rotated-out files
+// violate either limit, until stopped via gcDoneC.
+func (l *fileSink) runGCDaemon() {
+	for {
+		select {
+		case <-l.gcNotify:
+			l.gcOnce()
+		case <-l.gcDoneC:
+			return
+		}
+	}
+}
+
+// gcOnce lists this sink's rotated log files and removes whichever ones
+// selectFilesForRemoval flags, given the sink's current maxBackups and
+// logFilesCombinedMaxSize. The file currently being written to is never
+// a candidate: it is excluded from the listing before it ever reaches
+// selectFilesForRemoval, and it does not count against maxBackups
+// either, since maxBackups bounds the number of retained *backups*.
+func (l *fileSink) gcOnce() {
+	l.mu.Lock()
+	dir := l.mu.logDir
+	activeName := filepath.Base(l.mu.filename)
+	l.mu.Unlock()
+	if dir == "" {
+		return
+	}
+
+	files, err := listGCCandidates(l.fs, dir, l.prefix)
+	if err != nil {
+		fmt.Fprintf(OrigStderr, "log: gc: failed to list log files in %s: %s\n", dir, err)
+		return
+	}
+	backups := files[:0]
+	for _, f := range files {
+		if f.name == activeName {
+			continue
+		}
+		backups = append(backups, f)
+	}
+
+	combinedMaxSize := atomic.LoadInt64(&l.logFilesCombinedMaxSize)
+	for _, name := range selectFilesForRemoval(backups, l.maxBackups, combinedMaxSize) {
+		if err := l.fs.Remove(filepath.Join(dir, name)); err != nil && !oserror.IsNotExist(err) {
+			fmt.Fprintf(OrigStderr, "log: gc: failed to remove %s: %s\n", name, err)
+		}
+	}
+}
+
+// numCompressWorkers bounds the number of background goroutines used to
+// gzip rotated-out log files, so a burst of rotations cannot spawn an
+// unbounded number of goroutines.
+const numCompressWorkers = 2
+
+// compressQueueSize bounds how many rotated-out files can be queued for
+// compression before enqueueing blocks the rotating goroutine.
+const compressQueueSize = 16
+
+// startCompressWorkers starts the fixed-size pool of goroutines that
+// gzip-compress rotated-out log files enqueued via enqueueCompression.
+func (l *fileSink) startCompressWorkers() {
+	l.compressC = make(chan string, compressQueueSize)
+	for i := 0; i < numCompressWorkers; i++ {
+		l.compressWG.Add(1)
+		go l.compressWorker()
+	}
+}
+
+// compressWorker drains l.compressC, gzip-compressing each file in turn
+// until the channel is closed (by Shutdown).
+func (l *fileSink) compressWorker() {
+	defer l.compressWG.Done()
+	for name := range l.compressC {
+		l.compressAndNotifyGC(name)
+	}
+}
+
+// compressAndNotifyGC gzip-compresses name and, whether or not it
+// succeeded, wakes the GC daemon: a successfully compressed file is now
+// named name+compressedLogFileSuffix and should be accounted for under
+// that name instead of waiting for the next rotation's notify, and a
+// file left uncompressed after a failed attempt must still be
+// considered for logFilesCombinedMaxSize/maxBackups under its original
+// name.
+func (l *fileSink) compressAndNotifyGC(name string) {
+	if err := compressLogFile(l.fs, name, l.compressLevel); err != nil {
+		// Compression is best-effort: on failure, the original file
+		// is left in place and is still accounted for by GC.
+		fmt.Fprintf(OrigStderr, "log: failed to compress %s: %s\n", name, err)
+	}
+	l.notifyGC()
+}
+
+// enqueueCompression queues name for background gzip compression. It is
+// a no-op if compression is disabled or name is empty (no previous
+// file). If the queue is full, the file is compressed synchronously on
+// the caller's goroutine rather than dropped, so every rotated-out file
+// gets a compression attempt.
+func (l *fileSink) enqueueCompression(name string) {
+	if !l.compress || name == "" {
+		return
+	}
+	select {
+	case l.compressC <- name:
+	default:
+		l.compressAndNotifyGC(name)
+	}
+}
+
+// notifyGC wakes the GC daemon if it isn't already pending a run.
+func (l *fileSink) notifyGC() {
+	select {
+	case l.gcNotify <- struct{}{}:
+	default:
+	}
+}
+
+// compressedLogFileSuffix is appended to a rotated-out log file's name
+// once it has been gzip compressed.
+const compressedLogFileSuffix = ".gz"
+
+// compressLogFile gzip-compresses name into name+compressedLogFileSuffix
+// at the given gzip level, and removes the original file on success. It
+// goes through fsys for all file access, so it works against any logFS
+// backend (not just the local disk) and against the in-memory logFS
+// used by test Scopes.
+func compressLogFile(fsys logFS, name string, level int) (err error) {
+	src, err := fsys.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dstName := name + compressedLogFileSuffix
+	dst, err := fsys.Create(dstName)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closer, ok := dst.(interface{ Close() error }); ok {
+			if cerr := closer.Close(); err == nil {
+				err = cerr
+			}
+		}
+		if err != nil {
+			_ = fsys.Remove(dstName)
+		}
+	}()
+
+	gw, err := gzip.NewWriterLevel(dst, level)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(gw, src); err != nil {
+		return err
+	}
+	if err = gw.Close(); err != nil {
+		return err
+	}
+	if err = dst.Sync(); err != nil {
+		return err
+	}
+	return fsys.Remove(name)
+}
+
+// runRotateTicker drives time-based log rotation. It wakes up at every
+// boundary of l.rotateInterval (e.g. on the hour for an hourly interval)
+// and forces the sink onto a new file, independently of
+// logFileMaxSize. It runs until l.rotateDoneC is closed, which happens
+// when the sink is shut down.
+func (l *fileSink) runRotateTicker() {
+	for {
+		next := nextRotationBoundary(timeutil.Now(), l.rotateInterval)
+		timer := time.NewTimer(next.Sub(timeutil.Now()))
+		select {
+		case <-timer.C:
+			l.rotateOnIntervalBoundary(next)
+		case <-l.rotateDoneC:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// rotateOnIntervalBoundary forces the sink to start writing to a new
+// file aligned on the given boundary time, closing the previous file
+// once the new one is in place. Errors are logged but otherwise ignored: if we fail to rotate,
+// the sink keeps writing to the current file and will retry at the next
+// boundary.
+func (l *fileSink) rotateOnIntervalBoundary(boundary time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	oldFile := l.mu.file
+	if oldFile != nil {
+		l.flushAndSyncLocked(true /* doSync */)
+	}
+	l.mu.filesInInterval = 0
+	if err := l.createLocked(boundary); err != nil {
+		fmt.Fprintf(OrigStderr, "log: time-based rotation failed: %s\n", err)
+		return
+	}
+	if closer, ok := oldFile.(interface{ Close() error }); ok {
+		_ = closer.Close()
+	}
+}
+
+// Shutdown stops the background goroutines owned by this sink (the
+// time-based rotation ticker, the GC daemon, the async writer and the
+// compression worker pool) and waits for the async queue to drain and
+// any in-flight compression to finish, so that callers such as test
+// Scopes and graceful process shutdown don't race with these
+// goroutines.
+func (l *fileSink) Shutdown() {
+	if l.rotateDoneC != nil {
+		close(l.rotateDoneC)
+	}
+	if l.gcDoneC != nil {
+		close(l.gcDoneC)
+	}
+	if l.asyncC != nil {
+		close(l.asyncC)
+		l.asyncWG.Wait()
+	}
+	if l.compressC != nil {
+		close(l.compressC)
+		l.compressWG.Wait()
+	}
+}
+
+// nextRotationBoundary returns the next time strictly after t that is
+// aligned to interval, measured from midnight in t's own location
+// (rather than from the Unix epoch in UTC, as time.Truncate does) so
+// that e.g. an interval of 24h produces local-midnight boundaries
+// instead of 00:00 UTC ones on a non-UTC host. For interval durations
+// that evenly divide a day (the common case: hourly, daily, etc.), this
+// produces the expected wall-clock-aligned boundaries.
+func nextRotationBoundary(t time.Time, interval time.Duration) time.Time {
+	if interval <= 0 {
+		return t
+	}
+	loc := t.Location()
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+	elapsed := t.Sub(midnight)
+	truncated := midnight.Add((elapsed / interval) * interval)
+	if !truncated.After(t) {
+		truncated = truncated.Add(interval)
+	}
+	return truncated
+}
+
 // activeAtSeverity implements the logSink interface.
 func (l *fileSink) activeAtSeverity(sev logpb.Severity) bool {
 	return l.enabled.Get() && sev >= l.threshold
@@ -194,6 +818,10 @@ func (l *fileSink) output(extraSync bool, b []byte) error {
 		return nil
 	}
 
+	if l.asyncC != nil {
+		return l.asyncOutput(extraSync, b)
+	}
+
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
@@ -211,6 +839,154 @@ func (l *fileSink) output(extraSync bool, b []byte) error {
 	return nil
 }
 
+// defaultAsyncFlushInterval is how often the async writer goroutine
+// flushes on its own, so low-volume logs still land on disk promptly.
+const defaultAsyncFlushInterval = 100 * time.Millisecond
+
+// defaultAsyncQueueEntryBytes approximates the average size of a
+// formatted log entry, used to translate the asyncBufferBytes
+// configuration knob into a number of queue slots for asyncC.
+const defaultAsyncQueueEntryBytes = 256
+
+// startAsyncWriter puts this sink into async mode: output() enqueues
+// onto asyncC instead of writing on the caller's goroutine, and a
+// dedicated goroutine drains it. bufferBytes is translated into a
+// number of queue slots using defaultAsyncQueueEntryBytes.
+//
+// A sink with syncWrites set (e.g. an audit logger) refuses async mode
+// entirely: syncWrites promises that output() has synced the entry to
+// disk before returning, and that can't be honored for entries that
+// queue behind others on the writer goroutine.
+func (l *fileSink) startAsyncWriter(bufferBytes int64) {
+	if l.syncWrites {
+		return
+	}
+	capacity := int(bufferBytes / defaultAsyncQueueEntryBytes)
+	if capacity < 1 {
+		capacity = 1
+	}
+	l.asyncC = make(chan *asyncLogEntry, capacity)
+	l.asyncWG.Add(1)
+	go l.runAsyncWriter()
+}
+
+// isErrorOrFatalEntry reports whether the formatted log entry b is at
+// ERROR or FATAL severity, by inspecting its leading character. Entries
+// produced by this package's formatters always begin with a single
+// severity character (I, W, E or F).
+func isErrorOrFatalEntry(b []byte) bool {
+	if len(b) == 0 {
+		return false
+	}
+	return b[0] == 'E' || b[0] == 'F'
+}
+
+// asyncLogEntry is what output() queues onto asyncC for the async
+// writer goroutine to process.
+type asyncLogEntry struct {
+	data []byte
+
+	// extraSync records whether the original output() caller asked for
+	// extraSync, so writeAsyncEntry knows to flush+sync regardless of
+	// the dynamic logging.syncWrites flag.
+	extraSync bool
+
+	// synced, if non-nil, is closed by the writer goroutine once data
+	// has been written (and, if applicable, flushed/synced), so a
+	// caller blocking on an important entry can observe the outcome
+	// instead of returning as soon as the entry is merely queued. err
+	// is only meaningful for the caller once synced has been closed.
+	synced chan struct{}
+	err    error
+}
+
+// asyncOutput enqueues b onto asyncC for the async writer goroutine.
+// Entries below ERROR severity are dropped (and counted in
+// logsDropped) if the queue is full, so a slow disk cannot stall the
+// calling goroutine; ERROR and FATAL entries, and any entry with
+// extraSync set, block instead, to preserve durability of important
+// events, and their outcome (including any write error) is reported
+// back to the caller once the writer goroutine has processed them,
+// matching the error-reporting contract output() gives in non-async
+// mode.
+func (l *fileSink) asyncOutput(extraSync bool, b []byte) error {
+	blocking := extraSync || isErrorOrFatalEntry(b)
+	entry := &asyncLogEntry{data: append([]byte(nil), b...), extraSync: extraSync}
+	if blocking {
+		entry.synced = make(chan struct{})
+	}
+	if blocking {
+		l.asyncC <- entry
+	} else {
+		select {
+		case l.asyncC <- entry:
+		default:
+			atomic.AddUint64(&l.logsDropped, 1)
+			return nil
+		}
+	}
+	if entry.synced != nil {
+		<-entry.synced
+		return entry.err
+	}
+	return nil
+}
+
+// runAsyncWriter drains asyncC, writing each entry to the output file
+// under l.mu, until asyncC is closed and drained (by Shutdown). It also
+// flushes periodically so low-volume logs land on disk promptly even
+// without the queue filling up.
+func (l *fileSink) runAsyncWriter() {
+	defer l.asyncWG.Done()
+
+	flushInterval := l.asyncFlushInterval
+	if flushInterval <= 0 {
+		flushInterval = defaultAsyncFlushInterval
+	}
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case entry, ok := <-l.asyncC:
+			if !ok {
+				return
+			}
+			l.writeAsyncEntry(entry)
+		case <-ticker.C:
+			l.lockAndFlushAndSync(true /* doSync */)
+		}
+	}
+}
+
+// writeAsyncEntry writes a single entry dequeued by runAsyncWriter to
+// the output file, syncing if the entry asked for extraSync or the
+// dynamic logging.syncWrites flag is set (mirroring output()'s own
+// check), and signaling entry.synced (with entry.err set on failure)
+// so a blocked asyncOutput caller observes the outcome.
+func (l *fileSink) writeAsyncEntry(entry *asyncLogEntry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if entry.synced != nil {
+		defer close(entry.synced)
+	}
+
+	if err := l.ensureFileLocked(); err != nil {
+		fmt.Fprintf(OrigStderr, "log: async writer failed to open file: %s\n", err)
+		entry.err = err
+		return
+	}
+	if err := l.writeToFileLocked(entry.data); err != nil {
+		fmt.Fprintf(OrigStderr, "log: async writer failed to write: %s\n", err)
+		entry.err = err
+		return
+	}
+	if entry.extraSync || logging.syncWrites.Get() {
+		l.flushAndSyncLocked(true /* doSync */)
+	}
+}
+
 // exitCode implements the logSink interface.
 func (l *fileSink) exitCode() exit.Code {
 	return exit.LoggingFileUnavailable()
@@ -280,6 +1056,39 @@ func (l *fileSink) flushAndSyncLocked(doSync bool) {
 	}
 }
 
+// createLocked creates a new output file for this sink at time t,
+// installs it as l.mu.file, and records bookkeeping needed for
+// time-based rotation. If a file was previously open and compress is
+// set, it is enqueued for background gzip compression once replaced,
+// and in either case the GC daemon is
+// notified so it can enforce maxBackups/logFilesCombinedMaxSize against
+// the now-rotated-out file. l.mu is held.
+func (l *fileSink) createLocked(t time.Time) error {
+	// seq only disambiguates multiple files created within the same
+	// time-based rotation interval (see logName). Without time-based
+	// rotation there is nothing to disambiguate against, so every file
+	// must get a name without a ".<seq>" suffix; otherwise every size-triggered rotation after the first would
+	// grow filesInInterval and append a spurious ".1", ".2", ... to it.
+	seq := 0
+	if l.rotateInterval > 0 {
+		seq = l.mu.filesInInterval
+	}
+	f, updatedRotation, filename, symlink, err := create(
+		l.fs, l.mu.logDir, l.prefix, t, l.mu.lastRotation, l.filenamePattern, seq)
+	if err != nil {
+		return err
+	}
+	oldFilename := l.mu.filename
+	l.mu.lastRotation = updatedRotation
+	l.mu.filesInInterval++
+	l.mu.file = f
+	l.mu.filename = filename
+	createSymlink(l.fs, filename, symlink)
+	l.enqueueCompression(oldFilename)
+	l.notifyGC()
+	return nil
+}
+
 // DirName overrides (if non-empty) the choice of directory in
 // which to write logs.
 type DirName string
@@ -370,27 +1179,164 @@ func removePeriods(s string) string {
 }
 
 // logName returns a new log file name with start time t, and the name
-// for the symlink.
-func logName(prefix string, t time.Time) (name, link string) {
-	name = fmt.Sprintf("%s.%s.%s.%s.%06d.log",
-		removePeriods(prefix),
-		removePeriods(host),
-		removePeriods(userName),
-		t.Format(FileTimeFormat),
-		pid)
-	return name, removePeriods(prefix) + ".log"
+// for the symlink. If pattern is non-empty, it is expanded
+// strftime-style via formatFilename instead of using FileTimeFormat;
+// this lets operators line up rotated file names with external
+// log-shipping pipelines (e.g. "cockroach.2024-01-15T13.log"). seq
+// disambiguates a size-triggered rotation that falls within the same
+// time-based interval as a prior file: seq == 0 names the interval's
+// primary file, seq >= 1 appends ".<seq>" before the extension so the
+// primary file is never clobbered.
+func logName(prefix string, t time.Time, pattern string, seq int) (name, link string) {
+	link = removePeriods(prefix) + ".log"
+	if pattern != "" {
+		name = formatFilename(removePeriods(prefix)+".", t, pattern)
+	} else {
+		name = fmt.Sprintf("%s.%s.%s.%s.%06d",
+			removePeriods(prefix),
+			removePeriods(host),
+			removePeriods(userName),
+			t.Format(FileTimeFormat),
+			pid)
+	}
+	if seq > 0 {
+		name = fmt.Sprintf("%s.%d", name, seq)
+	}
+	name += ".log"
+	return name, link
+}
+
+// formatFilename expands strftime-style specifiers in pattern using t
+// and prepends prefix. The supported specifiers are %Y (4-digit year),
+// %m (2-digit month), %d (2-digit day), %H (2-digit hour), %M (2-digit
+// minute) and %S (2-digit second); any other text in pattern, including
+// unrecognized "%" sequences, is passed through unchanged.
+func formatFilename(prefix string, t time.Time, pattern string) string {
+	replacer := strings.NewReplacer(
+		"%Y", fmt.Sprintf("%04d", t.Year()),
+		"%m", fmt.Sprintf("%02d", t.Month()),
+		"%d", fmt.Sprintf("%02d", t.Day()),
+		"%H", fmt.Sprintf("%02d", t.Hour()),
+		"%M", fmt.Sprintf("%02d", t.Minute()),
+		"%S", fmt.Sprintf("%02d", t.Second()),
+	)
+	return prefix + replacer.Replace(pattern)
+}
+
+// stripCompressedSuffix removes the compressedLogFileSuffix (".gz") from
+// name if present. Code that parses a rotation timestamp out of a log
+// file name (e.g. the GC daemon that enforces logFilesCombinedMaxSize)
+// must strip the compression suffix first, since it is appended after
+// the ".log" extension produced by logName and is not part of the
+// timestamp-bearing name itself. Such code must also continue to treat
+// the stripped file as a log file for retention accounting purposes.
+func stripCompressedSuffix(name string) string {
+	return strings.TrimSuffix(name, compressedLogFileSuffix)
+}
+
+// gcLogFile describes a rotated log file as seen by the GC daemon that
+// enforces logFilesCombinedMaxSize and maxBackups for a given sink.
+type gcLogFile struct {
+	name      string
+	timestamp time.Time
+	size      int64
+}
+
+// parseGCTimestamp extracts the rotation timestamp embedded in a log
+// file's name by logName, for a file using the default (non
+// strftime-pattern) naming scheme. compressedLogFileSuffix, if present,
+// is stripped first so compressed files sort correctly alongside their
+// uncompressed siblings.
+func parseGCTimestamp(name string) (time.Time, bool) {
+	name = strings.TrimSuffix(stripCompressedSuffix(name), ".log")
+	// name is now "prefix.host.user.timestamp.pid" or
+	// "prefix.host.user.timestamp.pid.seq" (see logName's seq argument).
+	parts := strings.Split(name, ".")
+	if len(parts) < 4 {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(FileTimeFormat, parts[3])
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// listGCCandidates lists the rotated log files belonging to prefix in
+// dir, through fsys, for consumption by selectFilesForRemoval. Entries
+// that don't start with this sink's file name prefix (unrelated files
+// in dir) and the sink's symlink are skipped; a prefix-matching name
+// whose timestamp doesn't parse is listed with its mtime instead.
+func listGCCandidates(fsys logFS, dir, prefix string) ([]gcLogFile, error) {
+	entries, err := fsys.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	namePrefix := removePeriods(prefix) + "."
+	_, linkName := logName(prefix, time.Time{}, "", 0)
+	var files []gcLogFile
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasPrefix(e.Name(), namePrefix) || e.Name() == linkName {
+			continue
+		}
+		ts, ok := parseGCTimestamp(e.Name())
+		if !ok {
+			// The default naming scheme's timestamp didn't parse. This
+			// is expected for a sink configured with filenamePattern,
+			// whose names aren't in the "prefix.host.user.ts.pid" shape
+			// parseGCTimestamp understands. Rather than drop the file
+			// from retention accounting entirely (which would silently
+			// disable maxBackups/logFilesCombinedMaxSize for such
+			// sinks), fall back to its mtime: it already prefix-matched
+			// above, so it's one of this sink's log files.
+			ts = e.ModTime()
+		}
+		files = append(files, gcLogFile{name: e.Name(), timestamp: ts, size: e.Size()})
+	}
+	return files, nil
+}
+
+// selectFilesForRemoval returns the names of the files that should be
+// removed to enforce maxBackups and combinedMaxSize for one sink's log
+// files. files need not be presorted. A value of 0 for either limit
+// means that limit is not enforced. Files are walked newest-first: the
+// newest maxBackups files are the only ones maxBackups allows, and a
+// file is removed if adding it to the size of the files kept so far
+// would exceed combinedMaxSize. A large newer file can thus be removed
+// while a smaller older one that still fits is kept.
+func selectFilesForRemoval(files []gcLogFile, maxBackups int, combinedMaxSize int64) []string {
+	sorted := make([]gcLogFile, len(files))
+	copy(sorted, files)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].timestamp.After(sorted[j].timestamp)
+	})
+
+	var toRemove []string
+	var retainedSize int64
+	for i, f := range sorted {
+		exceedsBackups := maxBackups > 0 && i >= maxBackups
+		exceedsSize := combinedMaxSize > 0 && retainedSize+f.size > combinedMaxSize
+		if exceedsBackups || exceedsSize {
+			toRemove = append(toRemove, f.name)
+			continue
+		}
+		retainedSize += f.size
+	}
+	return toRemove
 }
 
 var errDirectoryNotSet = errors.New("log: log directory not set")
 
-// create creates a new log file and returns the file and its
-// filename. If the file is created successfully, create also attempts
-// to update the symlink for that tag, ignoring errors.
+// create creates a new log file through fsys and returns the file, its
+// filename and the path of the symlink for that tag. create does not
+// update the symlink itself; callers do that with createSymlink.
+//
+// pattern and seq control the generated file name, see logName.
 //
 // It is invalid to call this with an unset output directory.
 func create(
-	dir, prefix string, t time.Time, lastRotation int64,
-) (f *os.File, updatedRotation int64, filename, symlink string, err error) {
+	fsys logFS, dir, prefix string, t time.Time, lastRotation int64, pattern string, seq int,
+) (f flushSyncWriter, updatedRotation int64, filename, symlink string, err error) {
 	if dir == "" {
 		return nil, lastRotation, "", "", errDirectoryNotSet
 	}
@@ -405,21 +1351,24 @@ func create(
 	t = timeutil.Unix(unix, 0)
 
 	// Generate the file name.
-	name, link := logName(prefix, t)
+	name, link := logName(prefix, t, pattern, seq)
 	symlink = filepath.Join(dir, link)
 	fname := filepath.Join(dir, name)
-	// Open the file os.O_APPEND|os.O_CREATE rather than use os.Create.
+	// Open the file for append rather than truncate-and-write.
 	// Append is almost always more efficient than O_RDRW on most modern file systems.
-	f, err = os.OpenFile(fname, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	f, err = fsys.OpenAppend(fname)
 	return f, updatedRotation, fname, symlink, errors.Wrapf(err, "log: cannot create output file")
 }
 
-func createSymlink(fname, symlink string) {
-	// Symlinks are best-effort.
-	if err := os.Remove(symlink); err != nil && !oserror.IsNotExist(err) {
+// createSymlink updates the symlink for fname, best-effort, through
+// fsys. On filesystems that don't support symlinks (or logFS backends
+// that are not disk-based), failures here are ignored; they are logged,
+// except for a failure to create the symlink on Windows.
+func createSymlink(fsys logFS, fname, symlink string) {
+	if err := fsys.Remove(symlink); err != nil && !oserror.IsNotExist(err) {
 		fmt.Fprintf(OrigStderr, "log: failed to remove symlink %s: %s\n", symlink, err)
 	}
-	if err := os.Symlink(filepath.Base(fname), symlink); err != nil {
+	if err := fsys.Symlink(filepath.Base(fname), symlink); err != nil {
 		// On Windows, this will be the common case, as symlink creation
 		// requires special privileges.
 		// See: https://docs.microsoft.com/en-us/windows/device-security/security-policy-settings/create-symbolic-links
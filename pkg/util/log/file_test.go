@@ -0,0 +1,155 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package log
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestMemLogFS exercises memLogFS directly, and through compressLogFile,
+// to confirm it satisfies logFS well enough for a test Scope to run the
+// sink's rotation/compression/GC logic without touching a real
+// filesystem.
+func TestMemLogFS(t *testing.T) {
+	fsys := newMemLogFS()
+	var _ logFS = fsys
+
+	w, err := fsys.OpenAppend("dir/a.log")
+	require.NoError(t, err)
+	_, err = w.Write([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, w.Flush())
+	require.NoError(t, w.Sync())
+
+	info, err := fsys.Stat("dir/a.log")
+	require.NoError(t, err)
+	require.EqualValues(t, len("hello"), info.Size())
+
+	require.NoError(t, compressLogFile(fsys, "dir/a.log", gzip.BestSpeed))
+
+	_, err = fsys.Open("dir/a.log")
+	require.Error(t, err, "compressLogFile should have removed the source file")
+
+	r, err := fsys.Open("dir/a.log.gz")
+	require.NoError(t, err)
+	gz, err := gzip.NewReader(r)
+	require.NoError(t, err)
+	data, err := io.ReadAll(gz)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(data))
+
+	entries, err := fsys.ReadDir("dir")
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, "a.log.gz", entries[0].Name())
+}
+
+// TestSelectFilesForRemoval covers maxBackups, combinedMaxSize, and
+// their combination, including the edge case where the single newest
+// file alone exceeds combinedMaxSize: selectFilesForRemoval walks
+// newest-first and compares against the running total as it goes, so
+// that file is evicted even though an older, smaller file survives.
+func TestSelectFilesForRemoval(t *testing.T) {
+	mkFiles := func(sizes ...int64) []gcLogFile {
+		base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		files := make([]gcLogFile, len(sizes))
+		for i, size := range sizes {
+			// Earlier entries are older, so the newest file is last.
+			files[i] = gcLogFile{
+				name:      fmt.Sprintf("f%d.log", i),
+				timestamp: base.Add(time.Duration(i) * time.Hour),
+				size:      size,
+			}
+		}
+		return files
+	}
+
+	t.Run("maxBackups only", func(t *testing.T) {
+		removed := selectFilesForRemoval(mkFiles(1, 1, 1, 1), 2, 0)
+		require.ElementsMatch(t, []string{"f0.log", "f1.log"}, removed)
+	})
+
+	t.Run("combinedMaxSize only", func(t *testing.T) {
+		removed := selectFilesForRemoval(mkFiles(10, 10, 10), 0, 15)
+		require.ElementsMatch(t, []string{"f0.log", "f1.log"}, removed)
+	})
+
+	t.Run("oversized newest file still evicted ahead of an older smaller one", func(t *testing.T) {
+		files := []gcLogFile{
+			{name: "old.log", timestamp: time.Unix(0, 0), size: 5},
+			{name: "newbig.log", timestamp: time.Unix(1, 0), size: 100},
+		}
+		removed := selectFilesForRemoval(files, 0, 50)
+		require.Equal(t, []string{"newbig.log"}, removed)
+	})
+
+	t.Run("both limits combined", func(t *testing.T) {
+		removed := selectFilesForRemoval(mkFiles(5, 5, 5, 5), 3, 12)
+		require.ElementsMatch(t, []string{"f0.log", "f1.log"}, removed)
+	})
+
+	t.Run("neither limit enforced", func(t *testing.T) {
+		removed := selectFilesForRemoval(mkFiles(1, 2, 3), 0, 0)
+		require.Empty(t, removed)
+	})
+}
+
+// TestListGCCandidatesRetention creates many small files through a
+// memLogFS and asserts that listGCCandidates lists all of them and that
+// selectFilesForRemoval removes exactly as many as maxBackups/
+// combinedMaxSize require, for several combinations of the two limits.
+func TestListGCCandidatesRetention(t *testing.T) {
+	const dir = "logs"
+	const prefix = "cockroach"
+	const fileSize = 10
+
+	newFS := func(n int) logFS {
+		fsys := newMemLogFS()
+		base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		for i := 0; i < n; i++ {
+			name, _ := logName(prefix, base.Add(time.Duration(i)*time.Minute), "", 0)
+			w, err := fsys.OpenAppend(dir + "/" + name)
+			require.NoError(t, err)
+			_, err = w.Write(make([]byte, fileSize))
+			require.NoError(t, err)
+		}
+		return fsys
+	}
+
+	testCases := []struct {
+		numFiles        int
+		maxBackups      int
+		combinedMaxSize int64
+		wantRemoved     int
+	}{
+		{numFiles: 5, maxBackups: 0, combinedMaxSize: 0, wantRemoved: 0},
+		{numFiles: 5, maxBackups: 2, combinedMaxSize: 0, wantRemoved: 3},
+		{numFiles: 5, maxBackups: 0, combinedMaxSize: 3 * fileSize, wantRemoved: 2},
+		{numFiles: 5, maxBackups: 4, combinedMaxSize: 2 * fileSize, wantRemoved: 3},
+	}
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("files=%d/maxBackups=%d/combinedMaxSize=%d", tc.numFiles, tc.maxBackups, tc.combinedMaxSize), func(t *testing.T) {
+			fsys := newFS(tc.numFiles)
+			files, err := listGCCandidates(fsys, dir, prefix)
+			require.NoError(t, err)
+			require.Len(t, files, tc.numFiles)
+
+			removed := selectFilesForRemoval(files, tc.maxBackups, tc.combinedMaxSize)
+			require.Len(t, removed, tc.wantRemoved)
+		})
+	}
+}